@@ -0,0 +1,108 @@
+#!/usr/bin/env node
+
+const fs = require('fs');
+const { execFileSync } = require('child_process');
+const { token, connect } = require('./github');
+
+let failures = 0
+function ok(check, detail) {
+    console.log(`OK    ${check}: ${detail}`)
+}
+function fail(check, detail, fix) {
+    failures++
+    console.log(`FAIL  ${check}: ${detail}\n      ${fix}`)
+}
+
+function checkGit() {
+    try {
+        ok('git', execFileSync('git', ['--version']).toString().trim())
+    } catch (err) {
+        fail('git', 'not found on PATH', 'Install git; BORG_CLONE, BORG_WIKI and BORG_GISTS all shell out to it.')
+    }
+}
+
+// minisign is only run when there is a key to verify release signatures with
+function checkMinisign() {
+    if (!process.env.BORG_MINISIGN_KEY) return
+    try {
+        ok('minisign', execFileSync('minisign', ['-v']).toString().trim())
+    } catch (err) {
+        fail('minisign', 'not found on PATH', 'Install minisign, or unset BORG_MINISIGN_KEY to skip signature checks.')
+    }
+}
+
+// Only looks, never creates: a missing brig/ is fine as long as the run can create it
+function checkBrig() {
+    const dir = fs.existsSync('brig') ? 'brig' : '.'
+    try {
+        fs.accessSync(dir, fs.constants.W_OK)
+        ok('brig', dir === 'brig' ? 'writable' : 'missing, will be created on the first run')
+    } catch (err) {
+        fail('brig', err.message, 'Run from the repository root, or fix the permissions on brig/.')
+    }
+}
+
+function checkTargets() {
+    const file = process.env.BORG_TARGETS_FILE
+    if (!file) return
+    try {
+        fs.accessSync(file, fs.constants.R_OK)
+        ok('BORG_TARGETS_FILE', file)
+    } catch (err) {
+        fail('BORG_TARGETS_FILE', err.message, 'Point BORG_TARGETS_FILE at a readable file, or unset it.')
+    }
+}
+
+// Throttling is off so a drained quota is reported instead of waited out
+function checkGithubUrl() {
+    try {
+        const github = connect({ enabled: false })
+        ok('BORG_GITHUB_URL', github.url)
+        return github
+    } catch (err) {
+        fail('BORG_GITHUB_URL', err.message, 'Set BORG_GITHUB_URL to a web address such as https://github.example.com, or unset it.')
+    }
+}
+
+// GitHub answering 401 means the token was rejected; no status at all means GitHub was never reached
+function failGithub(github, err) {
+    if (err.status === 401) return fail('GITHUB_TOKEN', err.message, 'Create a new token at https://github.com/settings/tokens/new?scopes=repo')
+    if (err.status) return fail('BORG_GITHUB_URL', `${github.url} answered ${err.status}: ${err.message}`, 'Check that BORG_GITHUB_URL points at a GitHub install.')
+    fail('BORG_GITHUB_URL', `${github.url}: ${err.message}`, 'Check network access and BORG_GITHUB_URL.')
+}
+
+async function checkGithub(github) {
+    if (token) {
+        try {
+            const { data: user } = await github.octokit.request(`GET /user`)
+            ok('GITHUB_TOKEN', `authenticated as ${user.login}`)
+        } catch (err) {
+            // Tokens that cannot read their own user, such as app installation tokens, still collect public repos
+            if (err.status !== 403) return failGithub(github, err)
+            ok('GITHUB_TOKEN', 'cannot read GET /user, so only public repositories are listed for user targets')
+        }
+    } else {
+        ok('GITHUB_TOKEN', 'not set, requests are anonymous')
+    }
+
+    try {
+        const { data: { rate } } = await github.octokit.request(`GET /rate_limit`)
+        const detail = `${rate.remaining}/${rate.limit} remaining, resets ${new Date(rate.reset * 1000).toISOString()}`
+        if (rate.remaining > 0) return ok('rate limit', detail)
+        fail('rate limit', detail, 'Wait for the reset, or set GITHUB_TOKEN for a larger quota.')
+    } catch (err) {
+        failGithub(github, err)
+    }
+}
+
+async function doctor() {
+    checkGit()
+    checkMinisign()
+    checkBrig()
+    checkTargets()
+    const github = checkGithubUrl()
+    if (github) await checkGithub(github)
+    if (failures) process.exitCode = 1
+}
+
+module.exports = doctor();
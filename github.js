@@ -0,0 +1,37 @@
+const { Octokit } = require("@octokit/core");
+
+// Create a personal access token at https://github.com/settings/tokens/new?scopes=repo
+const token = process.env.GITHUB_TOKEN
+
+const MyActionOctokit = Octokit.plugin(
+    require("@octokit/plugin-paginate-rest").paginateRest,
+    require("@octokit/plugin-throttling").throttling,
+    require("@octokit/plugin-retry").retry
+).defaults({
+    userAgent: `my-octokit-action/v1.2.3`,
+});
+
+// GitHub Enterprise Server is addressed by its web URL; the API lives under /api/v3.
+// A malformed BORG_GITHUB_URL throws here, leaving the caller to decide how to report it.
+function connect(throttle) {
+    const url = (process.env.BORG_GITHUB_URL || 'https://github.com').replace(/\/+$/, '')
+    let parsed = {}
+    try {
+        parsed = new URL(url)
+    } catch (err) {
+        // reported below along with other unusable addresses
+    }
+    if (parsed.protocol !== 'https:' && parsed.protocol !== 'http:') {
+        throw new Error(`BORG_GITHUB_URL must be an http(s) address such as https://github.example.com, got "${url}"`)
+    }
+    return {
+        url: url,
+        octokit: new MyActionOctokit({
+            auth: token,
+            baseUrl: parsed.host === 'github.com' ? undefined : `${url}/api/v3`,
+            throttle: throttle,
+        }),
+    }
+}
+
+module.exports = { token, connect };
@@ -1,53 +1,592 @@
 #!/usr/bin/env node
 
+const fs = require('fs');
+const path = require('path');
+const crypto = require('crypto');
+const http = require('http');
+const https = require('https');
+const { pipeline, Transform } = require('stream');
+const { execFile } = require('child_process');
 const rm = require('rimraf').sync;
 const download = require('download-git-repo');
-const { Octokit } = require("@octokit/core");
+const github = require('./github');
 
 
 
-// Create a personal access token at https://github.com/settings/tokens/new?scopes=repo
+const token = github.token
+
+// Comma-separated settings, matched case-insensitively, e.g. "Go, JavaScript"
+function list(name) {
+    return process.env[name] ? process.env[name].toLowerCase().split(',').map((item) => item.trim()).filter(Boolean) : []
+}
+
+// Whole-number settings; anything else comes back as NaN rather than quietly turning the setting off
+function number(name, fallback) {
+    const value = (process.env[name] || '').trim()
+    if (!value) return fallback
+    return /^\d+$/.test(value) ? parseInt(value, 10) : NaN
+}
 
-const octokit = new Octokit();
 const target = process.env.BORG_TARGET ?  process.env.BORG_TARGET : process.argv[2] ? process.argv[2] : 'letheanVPN'
-console.log(`Scanning For: ${target}`)
-
-const MyActionOctokit = Octokit.plugin(
-    require("@octokit/plugin-paginate-rest").paginateRest,
-    require("@octokit/plugin-throttling").throttling,
-    require("@octokit/plugin-retry").retry
-).defaults({
-    throttle: {
-        onAbuseLimit: (retryAfter, options) => {
-            /* ... */
-        },
-        onRateLimit: (retryAfter, options) => {
-            /* ... */
-        },
+const ref = process.env.BORG_REF
+const clone = process.env.BORG_CLONE === 'true'
+const withIssues = process.env.BORG_ISSUES === 'true'
+const withWiki = process.env.BORG_WIKI === 'true'
+const withStarred = process.env.BORG_STARRED === 'true'
+const withGists = process.env.BORG_GISTS === 'true'
+const releases = process.env.BORG_RELEASES
+const releasesSince = process.env.BORG_RELEASES_SINCE
+const minisignKey = process.env.BORG_MINISIGN_KEY
+const artifacts = process.env.BORG_ARTIFACTS
+const skipForks = process.env.BORG_SKIP_FORKS === 'true'
+const skipArchived = process.env.BORG_SKIP_ARCHIVED === 'true'
+const maxSize = number('BORG_MAX_SIZE', 0)
+const languages = list('BORG_LANGUAGES')
+const topics = list('BORG_TOPICS')
+const graphql = process.env.BORG_GRAPHQL === 'true'
+const concurrency = number('BORG_CONCURRENCY', 4)
+const json = process.env.BORG_OUTPUT === 'json'
+const dryRun = process.env.BORG_DRY_RUN === 'true'
+
+// BORG_TARGETS_FILE lists one user or org per line; blank lines and # comments are skipped
+function readTargets() {
+    if (!process.env.BORG_TARGETS_FILE) return [target]
+    return fs.readFileSync(process.env.BORG_TARGETS_FILE, 'utf8').split(/\r?\n/).map((line) => line.replace(/#.*/, '').trim()).filter(Boolean)
+}
+
+// In json mode stdout carries only the final result, so progress goes to stderr
+const log = json ? console.error : console.log
+
+// Warnings are things that went wrong without failing a download; they end up in the report
+const warnings = []
+function warn(message) {
+    warnings.push(message)
+    log(message)
+}
+
+// Handed to the throttling plugin, so every wait ends up in the report
+const throttle = {
+    // Secondary limits come with a Retry-After; give up after a few attempts
+    onAbuseLimit: (retryAfter, options) => {
+        if (options.request.retryCount >= 3) {
+            warn(`Secondary Rate Limit: ${options.method} ${options.url}, giving up`)
+            return false
+        }
+        warn(`Secondary Rate Limit: ${options.method} ${options.url}, retrying in ${retryAfter}s`)
+        return true
     },
-    authStrategy: require("@octokit/auth-action").createActionAuth,
-    userAgent: `my-octokit-action/v1.2.3`,
-});
+    // retryAfter runs until the quota resets, so always wait it out
+    onRateLimit: (retryAfter, options) => {
+        warn(`Rate Limit Exhausted: ${options.method} ${options.url}, retrying in ${retryAfter}s`)
+        return true
+    },
+};
+
+// Set once getAll has connected, so a bad BORG_GITHUB_URL is reported like any other failure
+let octokit, githubUrl
+// Login of the GITHUB_TOKEN owner, once getAll has looked it up
+let login = ''
 
-async function getList() {
-    return await octokit.request(`GET /users/${target}/repos`, {
-        type: "public",
-
-    }).then((repos) => {
-        repos.data.forEach((repo) => {
-            console.log(`Brig Clean: ${repo.full_name}`)
-            rm(`brig/${repo.full_name}`)
-            download(`${repo.full_name}`, `brig/${repo.full_name}`, {
-                // clone: true, depth: 1
-            }, function (err) {
-                if (err) throw err
-                console.log(`Assimilated ${repo.full_name} Repository`)
+// The API answers archive and asset downloads with a redirect to a short-lived signed URL. Fetching that
+// URL needs no token, which also keeps the Authorization header away from the storage host.
+async function signedUrl(route, params) {
+    const { headers } = await octokit.request(route, Object.assign({}, params, { request: { redirect: 'manual' } }))
+    if (!headers.location) throw new Error(`${route} did not redirect to a download`)
+    return headers.location
+}
+
+// Streams url into file and resolves with the sha256 of what was written. Follows at most five
+// redirects, never from https down to http, and rejects rather than crashes if the connection drops.
+function save(url, file, redirects = 5) {
+    return new Promise((resolve, reject) => {
+        (url.startsWith('https:') ? https : http).get(url, (res) => {
+            if (res.statusCode >= 300 && res.statusCode < 400 && res.headers.location) {
+                res.resume()
+                const next = new URL(res.headers.location, url)
+                if (!redirects) return reject(new Error(`${path.basename(file)}: too many redirects`))
+                if (url.startsWith('https:') && next.protocol !== 'https:') return reject(new Error(`${path.basename(file)}: refused redirect to ${next.protocol}`))
+                return save(next.href, file, redirects - 1).then(resolve, reject)
+            }
+            if (res.statusCode !== 200) {
+                res.resume()
+                return reject(new Error(`${path.basename(file)}: HTTP ${res.statusCode}`))
+            }
+            const hash = crypto.createHash('sha256')
+            const tap = new Transform({
+                transform(chunk, encoding, callback) {
+                    hash.update(chunk)
+                    callback(null, chunk)
+                },
             })
+            pipeline(res, tap, fs.createWriteStream(file), (err) => err ? reject(err) : resolve(hash.digest('hex')))
+        }).on('error', reject)
+    })
+}
+
+// Fetches into brig/.tmp and replaces dest only once fetch succeeds, so a bad ref or a dropped connection
+// keeps the last good copy. No owner name starts with a dot, so brig/.tmp cannot collide with an archive.
+async function staged(dest, fetch) {
+    const tmp = dest.replace(/^brig\//, 'brig/.tmp/')
+    rm(tmp)
+    fs.mkdirSync(path.dirname(tmp), { recursive: true })
+    await fetch(tmp).catch((err) => {
+        rm(tmp)
+        throw err
+    })
+    rm(dest)
+    fs.mkdirSync(path.dirname(dest), { recursive: true })
+    fs.renameSync(tmp, dest)
+}
+
+function pull(source, dest, opts) {
+    return new Promise((resolve, reject) => download(source, dest, opts, (err) => err ? reject(err) : resolve()))
+}
+
+// Runs jobs with at most limit in flight, recording every outcome rather than stopping at the first failure
+async function pool(jobs, limit) {
+    const results = []
+    let next = 0
+    async function worker() {
+        while (next < jobs.length) {
+            const job = jobs[next++]
+            const started = Date.now()
+            const result = { name: job.name, size: job.size }
+            await job.run().then((extra) => Object.assign(result, extra), (err) => result.error = err.message)
+            result.seconds = (Date.now() - started) / 1000
+            results.push(result)
+        }
+    }
+    await Promise.all(Array.from({ length: Math.max(1, Math.min(limit, jobs.length)) }, worker))
+    return results
+}
+
+// Issues, pull requests and their comments are saved as JSON next to the repository folder.
+// The issues endpoint also returns pull requests, which already have a file of their own.
+async function getIssues(repo) {
+    const paginate = (path, params) => octokit.paginate(`GET /repos/${repo.full_name}/${path}`, Object.assign({ per_page: 100 }, params))
+    const files = {
+        issues: (await paginate(`issues`, { state: "all" })).filter((issue) => !issue.pull_request),
+        'issue-comments': await paginate(`issues/comments`),
+        pulls: await paginate(`pulls`, { state: "all" }),
+        'pull-comments': await paginate(`pulls/comments`),
+    }
+    fs.mkdirSync(`brig/${repo.owner.login}`, { recursive: true })
+    Object.keys(files).forEach((name) => fs.writeFileSync(`brig/${repo.full_name}.${name}.json`, JSON.stringify(files[name], null, 2)))
+    log(`Assimilated ${repo.full_name} Issues & Pull Requests`)
+}
+
+// Runs git without prompting. The token travels as a header set through GIT_CONFIG_* in git's
+// environment, which other local users cannot read the way they can read its command line.
+function git(args) {
+    const env = Object.assign({}, process.env, { GIT_TERMINAL_PROMPT: '0' })
+    if (token) Object.assign(env, {
+        GIT_CONFIG_COUNT: '1',
+        GIT_CONFIG_KEY_0: 'http.extraHeader',
+        GIT_CONFIG_VALUE_0: `Authorization: Basic ${Buffer.from(`x-access-token:${token}`).toString('base64')}`,
+    })
+    return new Promise((resolve, reject) => execFile('git', args, { env: env }, (err, stdout, stderr) => {
+        if (!err) return resolve(stdout)
+        reject(new Error(err.code === 'ENOENT' ? 'git not found on PATH' : stderr.trim() || `git exited with ${err.code}`))
+    }))
+}
+
+// has_wiki is true even when no page was ever written, so "not found" just means there is no wiki
+async function getWiki(repo) {
+    const dest = `brig/${repo.full_name}.wiki`
+    await staged(dest, async (tmp) => {
+        await git(['clone', '--quiet', '--depth', '1', `${githubUrl}/${repo.full_name}.wiki.git`, tmp])
+        rm(`${tmp}/.git`)
+    }).then(() => log(`Assimilated ${repo.full_name} Wiki`), (err) => {
+        if (/not found/i.test(err.message)) return log(`No Wiki for ${repo.full_name}`)
+        warn(`Wiki Failed: ${repo.full_name}: ${err.message}`)
+    })
+}
+
+// A 404 from a release or Actions listing means the repository has none, not that collection failed
+function none(err) {
+    if (err.status === 404) return []
+    throw err
+}
+
+// Published SHA256SUMS-style files list "<sha256>  <name>" per line
+function verifyChecksums(repo, release, dest, assets) {
+    const sums = {}
+    assets.filter((asset) => /sha256sums|checksums/i.test(asset.name)).forEach((file) => {
+        fs.readFileSync(`${dest}/${file.name}`, 'utf8').split(/\r?\n/).forEach((line) => {
+            const match = /^([0-9a-f]{64})\s+\*?(.+)$/i.exec(line.trim())
+            if (match) sums[path.basename(match[2])] = match[1].toLowerCase()
         })
-    });
+    })
+    assets.filter((asset) => sums[asset.name]).forEach((asset) => {
+        asset.checksum = sums[asset.name] === asset.sha256 ? 'ok' : 'mismatch'
+        if (asset.checksum === 'mismatch') warn(`Checksum Mismatch: ${repo.full_name} ${release.tag_name} ${asset.name}`)
+    })
 }
 
+// Only checked when BORG_MINISIGN_KEY holds the publisher's public key; minisign finds <asset>.minisig itself
+async function verifySignatures(repo, release, dest, assets) {
+    if (!minisignKey) return
+    for (const asset of assets.filter((asset) => assets.some((sig) => sig.name === `${asset.name}.minisig`))) {
+        asset.signature = await new Promise((resolve) => execFile('minisign', ['-Vq', '-P', minisignKey, '-m', `${dest}/${asset.name}`], (err) => {
+            resolve(!err ? 'valid' : err.code === 'ENOENT' ? 'unchecked' : 'invalid')
+        }))
+        if (asset.signature === 'unchecked') warn(`Signature Unchecked: ${repo.full_name} ${release.tag_name} ${asset.name}: minisign not found on PATH`)
+        if (asset.signature === 'invalid') warn(`Signature Invalid: ${repo.full_name} ${release.tag_name} ${asset.name}`)
+    }
+}
+
+// Releases live beside the owner rather than the repository, since no owner name contains a dot
+async function getRelease(repo, release) {
+    const summary = {
+        tag: release.tag_name,
+        name: release.name,
+        published: release.published_at,
+        prerelease: release.prerelease,
+        url: release.html_url,
+    }
+    await staged(`brig/${repo.owner.login}.releases/${repo.name}/${release.tag_name}`, async (dest) => {
+        fs.mkdirSync(dest, { recursive: true })
+        const assets = []
+        for (const asset of release.assets) {
+            const url = await signedUrl(`GET /repos/{owner}/{repo}/releases/assets/{asset_id}`, {
+                owner: repo.owner.login, repo: repo.name, asset_id: asset.id, headers: { accept: 'application/octet-stream' },
+            })
+            assets.push({ name: asset.name, size: asset.size, sha256: await save(url, `${dest}/${asset.name}`) })
+        }
+        verifyChecksums(repo, release, dest, assets)
+        await verifySignatures(repo, release, dest, assets)
+        fs.writeFileSync(`${dest}/RELEASE.md`, release.body || '')
+        fs.writeFileSync(`${dest}/release.json`, JSON.stringify(Object.assign({}, summary, { assets: assets }), null, 2))
+    })
+    return summary
+}
+
+// The index keeps versions archived by earlier runs, so BORG_RELEASES_SINCE runs add to the history
+function writeReleaseIndex(repo, collected) {
+    const file = `brig/${repo.owner.login}.releases/${repo.name}/index.json`
+    const tags = new Set(collected.map((release) => release.tag))
+    const earlier = fs.existsSync(file) ? JSON.parse(fs.readFileSync(file, 'utf8')).filter((release) => !tags.has(release.tag)) : []
+    const index = collected.concat(earlier).sort((a, b) => String(b.published).localeCompare(String(a.published)))
+    fs.writeFileSync(file, JSON.stringify(index, null, 2))
+}
 
-console.log(`We are the Borg. Your technological distinctiveness will be added to our own & contributed too`)
+// Releases are listed newest first, so BORG_RELEASES_SINCE can stop paging as soon as it meets that tag
+async function listReleases(repo) {
+    let found = false
+    const list = await octokit.paginate(`GET /repos/${repo.full_name}/releases`, { per_page: 100 }, (response, done) => {
+        const at = releasesSince ? response.data.findIndex((release) => release.tag_name === releasesSince) : -1
+        if (at < 0) return response.data
+        found = true
+        done()
+        return response.data.slice(0, at)
+    })
+    if (releasesSince && !found && list.length) warn(`Release ${releasesSince} not found in ${repo.full_name}, collected every release`)
+    return list
+}
 
-module.exports = getList();
+// BORG_RELEASES=latest takes the newest full release, BORG_RELEASES=all walks the history
+function findReleases(repo) {
+    return releases === 'latest'
+        ? octokit.request(`GET /repos/${repo.full_name}/releases/latest`).then((res) => [res.data], none)
+        : listReleases(repo)
+}
+
+async function getReleases(repo) {
+    const list = await findReleases(repo)
+    const collected = []
+    for (const release of list) collected.push(await getRelease(repo, release))
+    if (!collected.length) return
+    writeReleaseIndex(repo, collected)
+    log(`Assimilated ${repo.full_name} Releases (${collected.length})`)
+}
+
+// BORG_ARTIFACTS=latest takes the newest successful run on the default branch, BORG_ARTIFACTS=all every
+// unexpired artifact.
+async function findArtifacts(repo) {
+    const list = artifacts === 'latest'
+        ? await octokit.request(`GET /repos/${repo.full_name}/actions/runs`, { branch: repo.default_branch, status: "success", per_page: 1 })
+            .then((res) => res.data.workflow_runs.length
+                ? octokit.paginate(`GET /repos/${repo.full_name}/actions/runs/${res.data.workflow_runs[0].id}/artifacts`, { per_page: 100 })
+                : [], none)
+        : await octokit.paginate(`GET /repos/${repo.full_name}/actions/artifacts`, { per_page: 100 }).catch(none)
+    return list.filter((artifact) => !artifact.expired)
+}
+
+// Artifacts live beside the owner for the same reason as releases
+async function getArtifacts(repo) {
+    const unexpired = await findArtifacts(repo)
+    if (!unexpired.length) return
+    await staged(`brig/${repo.owner.login}.artifacts/${repo.name}`, async (dest) => {
+        const saved = []
+        for (const artifact of unexpired) {
+            const run = artifact.workflow_run ? artifact.workflow_run.id : 'unknown-run'
+            const url = await signedUrl(`GET /repos/{owner}/{repo}/actions/artifacts/{artifact_id}/{archive_format}`, {
+                owner: repo.owner.login, repo: repo.name, artifact_id: artifact.id, archive_format: 'zip',
+            })
+            fs.mkdirSync(`${dest}/${run}`, { recursive: true })
+            saved.push({ name: artifact.name, run: run, created: artifact.created_at, size: artifact.size_in_bytes, sha256: await save(url, `${dest}/${run}/${artifact.name}.zip`) })
+        }
+        fs.writeFileSync(`${dest}/artifacts.json`, JSON.stringify(saved, null, 2))
+    })
+    log(`Assimilated ${repo.full_name} Artifacts (${unexpired.length})`)
+}
+
+// GET /rate_limit does not count against the quota it reports
+async function getRateLimit() {
+    const { data: { rate } } = await octokit.request(`GET /rate_limit`)
+    log(`Rate Limit: ${rate.remaining}/${rate.limit} remaining, resets ${new Date(rate.reset * 1000).toISOString()}`)
+    return rate
+}
+
+// Returns why a repository is filtered out, or an empty string to keep it; repo.size is in KB
+function skipReason(repo) {
+    if (skipForks && repo.fork) return 'fork'
+    if (skipArchived && repo.archived) return 'archived'
+    if (maxSize && repo.size > maxSize) return `${repo.size} KB is over BORG_MAX_SIZE`
+    if (languages.length && !languages.includes(String(repo.language).toLowerCase())) return `language ${repo.language}`
+    if (topics.length && !(repo.topics || []).some((topic) => topics.includes(topic))) return 'no matching topic'
+    return ''
+}
+
+// sha256 over every file's relative path and contents, walked in sorted order. Symlinks are hashed by
+// their target rather than followed, since they may dangle or point at a directory.
+function hashDir(dir) {
+    const hash = crypto.createHash('sha256')
+    const walk = (rel) => fs.readdirSync(path.join(dir, rel), { withFileTypes: true })
+        .sort((a, b) => a.name < b.name ? -1 : a.name > b.name ? 1 : 0)
+        .forEach((entry) => {
+            const file = path.posix.join(rel, entry.name)
+            if (entry.isDirectory()) return walk(file)
+            if (entry.isSymbolicLink()) return hash.update(file).update('\x01').update(fs.readlinkSync(path.join(dir, file)))
+            hash.update(file).update('\0').update(fs.readFileSync(path.join(dir, file)))
+        })
+    walk('')
+    return hash.digest('hex')
+}
+
+// Written after the download so the content hash does not cover the provenance file itself
+function writeProvenance(repo, checkout, sha) {
+    const dest = `brig/${repo.full_name}`
+    const provenance = {
+        source: repo.html_url,
+        commit: sha,
+        ref: checkout,
+        collected: new Date().toISOString(),
+        collector: `borg/${require('./package.json').version}`,
+        sha256: hashDir(dest),
+    }
+    fs.writeFileSync(`${dest}/.borg-provenance.json`, JSON.stringify(provenance, null, 2))
+    return provenance
+}
+
+// Fetches only the pinned commit: no history, no other branches, and blobs only as the checkout needs them
+async function cloneCommit(repo, sha, dest) {
+    fs.mkdirSync(dest, { recursive: true })
+    await git(['-C', dest, 'init', '--quiet'])
+    await git(['-C', dest, 'remote', 'add', 'origin', `${githubUrl}/${repo.full_name}.git`])
+    await git(['-C', dest, 'fetch', '--quiet', '--depth', '1', '--filter=blob:none', 'origin', sha])
+    await git(['-C', dest, 'checkout', '--quiet', 'FETCH_HEAD'])
+    rm(`${dest}/.git`)
+}
+
+async function getRepo(repo) {
+    const checkout = ref ? ref : repo.default_branch
+    // Pin the ref to a commit first, so the provenance names exactly what was downloaded even if the branch moves
+    const { data: commit } = await octokit.request(`GET /repos/${repo.full_name}/commits/${checkout}`)
+    await staged(`brig/${repo.full_name}`, async (dest) => {
+        if (clone) {
+            await cloneCommit(repo, commit.sha, dest)
+        } else {
+            // The zipball endpoint, unlike github.com/<repo>/archive, honours the token for private repositories
+            const url = await signedUrl(`GET /repos/{owner}/{repo}/zipball/{ref}`, { owner: repo.owner.login, repo: repo.name, ref: commit.sha })
+            await pull(`direct:${url}`, dest, {})
+        }
+    })
+    const provenance = writeProvenance(repo, checkout, commit.sha)
+    log(`Assimilated ${repo.full_name} Repository`)
+    if (withIssues && repo.has_issues) await getIssues(repo).catch((err) => warn(`Issues Failed: ${repo.full_name}: ${err.message}`))
+    if (withWiki && repo.has_wiki) await getWiki(repo)
+    if (releases) await getReleases(repo).catch((err) => warn(`Releases Failed: ${repo.full_name}: ${err.message}`))
+    if (artifacts) await getArtifacts(repo).catch((err) => warn(`Artifacts Failed: ${repo.full_name}: ${err.message}`))
+    return { path: `brig/${repo.full_name}`, commit: provenance.commit, sha256: provenance.sha256 }
+}
+
+// Gists have no fixed default branch, so check out whatever HEAD points at. They live under
+// <owner>.gists because no repository, and so no brig/<owner>/<repo> folder, can be named that.
+async function getGist(gist) {
+    const name = `${gist.owner.login}.gists/${gist.id}`
+    await staged(`brig/${name}`, (dest) => pull(`direct:${gist.git_pull_url}#HEAD`, dest, {
+        clone: true,
+    }))
+    log(`Assimilated ${name} Gist`)
+    return { path: `brig/${name}` }
+}
+
+const REPOSITORIES = `query ($login: String!, $cursor: String, $privacy: RepositoryPrivacy) {
+    repositoryOwner(login: $login) {
+        repositories(first: 100, after: $cursor, privacy: $privacy, ownerAffiliations: [OWNER]) {
+            pageInfo { hasNextPage endCursor }
+            nodes {
+                name nameWithOwner url owner { login } defaultBranchRef { name }
+                diskUsage isFork isArchived isPrivate hasIssuesEnabled hasWikiEnabled
+                primaryLanguage { name } repositoryTopics(first: 100) { nodes { topic { name } } }
+            }
+        }
+    }
+}`
+
+// One GraphQL page carries 100 repositories with everything the filters need, shaped like the REST
+// listing so the rest of the script cannot tell the difference. GraphQL only accepts authenticated calls.
+// Like the REST listing, private repositories are only included for the token owner's own account.
+async function graphqlRepos(target) {
+    if (!token) throw new Error('BORG_GRAPHQL needs a GITHUB_TOKEN')
+    const privacy = login && login.toLowerCase() === target.toLowerCase() ? null : 'PUBLIC'
+    const repos = []
+    let cursor = null
+    do {
+        const { repositoryOwner: owner } = await octokit.graphql(REPOSITORIES, { login: target, cursor: cursor, privacy: privacy })
+        if (!owner) throw new Error(`${target} not found`)
+        owner.repositories.nodes.forEach((node) => repos.push({
+            name: node.name,
+            full_name: node.nameWithOwner,
+            html_url: node.url,
+            owner: { login: node.owner.login },
+            default_branch: node.defaultBranchRef ? node.defaultBranchRef.name : null,
+            size: node.diskUsage || 0,
+            fork: node.isFork,
+            archived: node.isArchived,
+            private: node.isPrivate,
+            has_issues: node.hasIssuesEnabled,
+            has_wiki: node.hasWikiEnabled,
+            language: node.primaryLanguage ? node.primaryLanguage.name : null,
+            topics: node.repositoryTopics.nodes.map((topic) => topic.topic.name),
+        }))
+        cursor = owner.repositories.pageInfo.hasNextPage ? owner.repositories.pageInfo.endCursor : null
+    } while (cursor)
+    return repos
+}
+
+// Only the authenticated user's own listing includes their private repositories;
+// team listings need a GITHUB_TOKEN that can see the team
+function listRepos(target, team) {
+    if (team) return octokit.paginate(`GET /orgs/${target}/teams/${team}/repos`, { per_page: 100 })
+    if (graphql) return graphqlRepos(target)
+    if (login && login.toLowerCase() === target.toLowerCase()) return octokit.paginate(`GET /user/repos`, { affiliation: "owner", per_page: 100 })
+    return octokit.paginate(`GET /users/${target}/repos`, { type: "public", per_page: 100 })
+}
+
+// An "org/team" target collects only that team's repositories; starred repos and gists still belong to the org
+// API listings give release asset and artifact sizes in bytes; everything else is counted in KB
+const kb = (bytes) => Math.ceil(bytes / 1024)
+
+// What a dry run adds for a repository's releases and artifacts, sized from their listings
+async function planExtras(repo) {
+    const plan = {}
+    if (releases) {
+        const list = await findReleases(repo)
+        plan.releases = { count: list.length, size: kb(list.reduce((total, release) => total + release.assets.reduce((sum, asset) => sum + asset.size, 0), 0)) }
+    }
+    if (artifacts) {
+        const list = await findArtifacts(repo)
+        plan.artifacts = { count: list.length, size: kb(list.reduce((total, artifact) => total + artifact.size_in_bytes, 0)) }
+    }
+    return plan
+}
+
+// A planned result's size, including its releases and artifacts
+const planned = (result) => result.size + (result.releases ? result.releases.size : 0) + (result.artifacts ? result.artifacts.size : 0)
+
+async function getList(name) {
+    const [target, team] = name.split('/')
+    log(`Scanning For: ${name}`)
+    let repos = await listRepos(target, team)
+    if (withStarred) {
+        // Users can star their own repositories; two jobs on one folder would clobber each other
+        const seen = new Set(repos.map((repo) => repo.full_name))
+        const starred = await octokit.paginate(`GET /users/${target}/starred`, { per_page: 100 })
+        repos = repos.concat(starred.filter((repo) => !seen.has(repo.full_name)))
+    }
+    const skipped = repos.filter(skipReason).map((repo) => ({ name: repo.full_name, reason: skipReason(repo) }))
+    let jobs = repos.filter((repo) => !skipReason(repo)).map((repo) => ({ name: repo.full_name, size: repo.size, repo: repo, run: () => getRepo(repo) }))
+    if (withGists) {
+        const gists = await octokit.paginate(`GET /users/${target}/gists`, { per_page: 100 })
+        const gistSize = (gist) => kb(Object.values(gist.files || {}).reduce((total, file) => total + (file.size || 0), 0))
+        jobs = jobs.concat(gists.map((gist) => ({ name: `${gist.owner.login}.gists/${gist.id}`, size: gistSize(gist), run: () => getGist(gist) })))
+    }
+
+    // Sizes come from the API listings, so a dry run costs no downloads. Issues and wikis have no
+    // size to ask for, so the total leaves them out.
+    if (dryRun) {
+        const results = []
+        for (const job of jobs) {
+            const result = { name: job.name, size: job.size }
+            if (job.repo) Object.assign(result, await planExtras(job.repo).catch((err) => {
+                warn(`Plan Failed: ${job.name}: ${err.message}`)
+                return {}
+            }))
+            const extras = ['releases', 'artifacts'].filter((kind) => result[kind]).map((kind) => `, ${result[kind].count} ${kind} ${result[kind].size} KB`)
+            log(`Would Assimilate: ${job.name} (${job.size} KB${extras.join('')})`)
+            results.push(result)
+        }
+        const unsized = withIssues || withWiki ? ', not counting issues and wikis' : ''
+        log(`Would Assimilate ${name}: ${results.length} (${results.reduce((total, result) => total + planned(result), 0)} KB${unsized})`)
+        return { results: results, skipped: skipped }
+    }
+
+    const results = await pool(jobs, concurrency)
+    const failed = results.filter((result) => result.error)
+    const size = results.reduce((total, result) => total + result.size, 0)
+    log(`Assimilated ${name}: ${results.length - failed.length}/${results.length} (${size} KB)`)
+    failed.forEach((result) => log(`Failed: ${result.name}: ${result.error}`))
+    return { results: results, skipped: skipped }
+}
+
+// Targets run one after another; each gets the full download pool to itself
+async function getAll() {
+    if (releases && !['latest', 'all'].includes(releases)) throw new Error(`BORG_RELEASES must be latest or all, got "${releases}"`)
+    if (artifacts && !['latest', 'all'].includes(artifacts)) throw new Error(`BORG_ARTIFACTS must be latest or all, got "${artifacts}"`)
+    if (artifacts && !token) throw new Error('BORG_ARTIFACTS needs a GITHUB_TOKEN; GitHub only serves artifacts to signed-in users')
+    if (Number.isNaN(maxSize)) throw new Error(`BORG_MAX_SIZE must be a whole number of KB, got "${process.env.BORG_MAX_SIZE}"`)
+    if (!(concurrency >= 1)) throw new Error(`BORG_CONCURRENCY must be a whole number above 0, got "${process.env.BORG_CONCURRENCY}"`)
+    const started = new Date().toISOString()
+    const connection = github.connect(throttle)
+    octokit = connection.octokit
+    githubUrl = connection.url
+    const quota = { start: await getRateLimit() }
+    // Tokens that cannot read their own user, such as app installation tokens, simply list public repos
+    if (token) login = (await octokit.request(`GET /user`).catch(() => ({ data: {} }))).data.login
+    const runs = []
+    for (const target of readTargets()) {
+        const run = await getList(target).catch((err) => {
+            log(`Scan Failed: ${target}: ${err.message}`)
+            return { results: [{ name: target, size: 0, error: err.message }], skipped: [] }
+        })
+        runs.push({ target: target, results: run.results, skipped: run.skipped })
+    }
+    if (runs.length > 1) {
+        const results = [].concat(...runs.map((run) => run.results))
+        log(`${dryRun ? 'Would Assimilate' : 'Assimilated'} ${runs.length} Targets: ${results.filter((result) => !result.error).length}/${results.length}`)
+    }
+
+    rm('brig/.tmp')
+    quota.end = await getRateLimit()
+    const report = { started: started, finished: new Date().toISOString(), dryRun: dryRun, rateLimit: quota, targets: runs, warnings: warnings }
+    if (!dryRun) {
+        fs.mkdirSync('brig', { recursive: true })
+        fs.writeFileSync('brig/report.json', JSON.stringify(report, null, 2))
+    }
+    return report
+}
+
+log(`We are the Borg. Your technological distinctiveness will be added to our own & contributed too`)
+
+// Any failed download or scan makes the run fail, so cron and CI notice
+module.exports = getAll().then((report) => {
+    if (json) console.log(JSON.stringify(report, null, 2))
+    if (report.targets.some((run) => run.results.some((result) => result.error))) process.exitCode = 1
+    return report
+}).catch((err) => {
+    console.error(`Borg Failed: ${err.message}`)
+    if (json) console.log(JSON.stringify({ error: err.message }, null, 2))
+    process.exitCode = 1
+});